@@ -0,0 +1,310 @@
+// Package internal implements a small HTTP client for the Technitium DNS
+// Server API, shared by the provider's record operations.
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthMode selects how the API token is presented to the Technitium server.
+type AuthMode string
+
+const (
+	// AuthModeBearer sends the token as an "Authorization: Bearer" header.
+	// This is supported by all reasonably recent Technitium versions and is
+	// the default, since it keeps the token out of access logs.
+	AuthModeBearer AuthMode = "bearer"
+
+	// AuthModeQuery sends the token as a "token" query parameter, for
+	// Technitium versions too old to accept bearer tokens.
+	AuthModeQuery AuthMode = "query"
+)
+
+// Client is a minimal client for the Technitium DNS Server HTTP API.
+//
+// Either APIToken or Username/Password must be set. When Username/Password
+// are used, the client logs in lazily on first use, caches the resulting
+// session token in memory, and transparently re-authenticates if the token
+// expires (per TokenTTL) or is rejected by the server.
+type Client struct {
+	BaseURL    string
+	APIToken   string
+	Username   string
+	Password   string
+	AuthMode   AuthMode
+	TokenTTL   time.Duration
+	HTTPClient *http.Client
+
+	mu           sync.Mutex
+	sessionToken string
+	tokenExpiry  time.Time
+}
+
+// usesSessionAuth reports whether the client authenticates via a
+// username/password login rather than a static API token.
+func (c *Client) usesSessionAuth() bool {
+	return c.Username != ""
+}
+
+// token returns the token to present to the server, logging in (or
+// re-logging in, if TokenTTL has elapsed) when using session auth.
+func (c *Client) token(ctx context.Context) (string, error) {
+	if !c.usesSessionAuth() {
+		return c.APIToken, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sessionToken != "" && (c.TokenTTL == 0 || time.Now().Before(c.tokenExpiry)) {
+		return c.sessionToken, nil
+	}
+
+	return c.loginLocked(ctx)
+}
+
+// Login authenticates with Username/Password and caches the resulting
+// session token. It is called automatically on first use and on token
+// expiry or rejection, but is exported so Provision can fail fast on bad
+// credentials.
+func (c *Client) Login(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.loginLocked(ctx)
+	return err
+}
+
+// loginLocked performs the actual /api/user/login call. c.mu must be held.
+func (c *Client) loginLocked(ctx context.Context) (string, error) {
+	params := url.Values{}
+	params.Set("user", c.Username)
+	params.Set("pass", c.Password)
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := c.doUnauthenticated(ctx, "/api/user/login", params, &result); err != nil {
+		return "", fmt.Errorf("failed to log in: %v", err)
+	}
+
+	c.sessionToken = result.Token
+	if c.TokenTTL > 0 {
+		c.tokenExpiry = time.Now().Add(c.TokenTTL)
+	}
+
+	return c.sessionToken, nil
+}
+
+// Logout invalidates the current session token, if any, via
+// /api/user/logout. It is a no-op when using a static API token or when no
+// session has been established.
+func (c *Client) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	token := c.sessionToken
+	c.sessionToken = ""
+	c.tokenExpiry = time.Time{}
+	c.mu.Unlock()
+
+	if !c.usesSessionAuth() || token == "" {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("token", token)
+	if err := c.doUnauthenticated(ctx, "/api/user/logout", params, nil); err != nil {
+		return fmt.Errorf("failed to log out: %v", err)
+	}
+	return nil
+}
+
+// APIError is returned when the Technitium API reports a non-"ok" status.
+type APIError struct {
+	StatusCode   int
+	Status       string
+	ErrorMessage string
+	StackTrace   string
+}
+
+func (e *APIError) Error() string {
+	if e.ErrorMessage != "" {
+		return fmt.Sprintf("technitium API error (status %q): %s", e.Status, e.ErrorMessage)
+	}
+	return fmt.Sprintf("technitium API returned unexpected status %q (http %d)", e.Status, e.StatusCode)
+}
+
+// apiResponse is the envelope every Technitium API call responds with.
+type apiResponse struct {
+	Status       string          `json:"status"`
+	ErrorMessage string          `json:"errorMessage"`
+	StackTrace   string          `json:"stackTrace"`
+	Response     json.RawMessage `json:"response"`
+}
+
+// Record is a single entry as returned by the zones/records/get endpoint.
+type Record struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	TTL   int             `json:"ttl"`
+	RData json.RawMessage `json:"rData"`
+}
+
+// GetZoneRecords lists every record in zone via zones/records/get.
+func (c *Client) GetZoneRecords(ctx context.Context, zone string) ([]Record, error) {
+	params := url.Values{}
+	params.Set("domain", strings.TrimSuffix(zone, "."))
+	params.Set("zone", strings.TrimSuffix(zone, "."))
+	params.Set("listZone", "true")
+
+	var result struct {
+		Records []Record `json:"records"`
+	}
+	if err := c.do(ctx, "/api/zones/records/get", params, &result); err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+// AddRecord creates a record via zones/records/add. typeParams carries the
+// type-specific fields (e.g. "ipAddress" for A/AAAA, "text" for TXT).
+func (c *Client) AddRecord(ctx context.Context, domain, recordType string, ttl int, typeParams url.Values) error {
+	params := cloneValues(typeParams)
+	params.Set("domain", strings.TrimSuffix(domain, "."))
+	params.Set("type", recordType)
+	params.Set("ttl", fmt.Sprintf("%d", ttl))
+
+	return c.do(ctx, "/api/zones/records/add", params, nil)
+}
+
+// DeleteRecord removes a record via zones/records/delete. Technitium matches
+// on the identifying type-specific fields, so no TTL is sent.
+func (c *Client) DeleteRecord(ctx context.Context, domain, recordType string, typeParams url.Values) error {
+	params := cloneValues(typeParams)
+	params.Set("domain", strings.TrimSuffix(domain, "."))
+	params.Set("type", recordType)
+
+	return c.do(ctx, "/api/zones/records/delete", params, nil)
+}
+
+// UpdateRecord replaces an existing record in place via zones/records/update.
+// The record being replaced is identified by domain, recordType, and
+// oldTypeParams; its replacement is carried in the "new"-prefixed
+// newDomain/newTTL/newTypeParams.
+func (c *Client) UpdateRecord(ctx context.Context, domain, recordType string, oldTypeParams url.Values, newDomain string, newTTL int, newTypeParams url.Values) error {
+	params := cloneValues(oldTypeParams)
+	params.Set("domain", strings.TrimSuffix(domain, "."))
+	params.Set("type", recordType)
+	params.Set("newDomain", strings.TrimSuffix(newDomain, "."))
+	params.Set("newTtl", fmt.Sprintf("%d", newTTL))
+	for key, values := range newTypeParams {
+		params.Set("new"+strings.ToUpper(key[:1])+key[1:], values[0])
+	}
+
+	return c.do(ctx, "/api/zones/records/update", params, nil)
+}
+
+// do issues an authenticated POST request against endpoint, decoding the
+// "response" field of the result into out (if non-nil). When using session
+// auth, a 401 triggers exactly one re-login and retry, in case the cached
+// token expired server-side before TokenTTL predicted it would.
+func (c *Client) do(ctx context.Context, endpoint string, params url.Values, out interface{}) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	statusCode, err := c.doWithToken(ctx, endpoint, params, token, out)
+	if err == nil || statusCode != http.StatusUnauthorized || !c.usesSessionAuth() {
+		return err
+	}
+
+	c.mu.Lock()
+	c.sessionToken = ""
+	c.mu.Unlock()
+
+	token, err = c.token(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.doWithToken(ctx, endpoint, params, token, out)
+	return err
+}
+
+// doUnauthenticated issues a POST request with no auth applied, for the
+// login/logout endpoints which take their credentials as regular params.
+func (c *Client) doUnauthenticated(ctx context.Context, endpoint string, params url.Values, out interface{}) error {
+	_, err := c.doWithToken(ctx, endpoint, params, "", out)
+	return err
+}
+
+// doWithToken issues the actual HTTP request, applying token per AuthMode
+// when non-empty, and returns the HTTP status code alongside any error so
+// callers can detect 401s.
+func (c *Client) doWithToken(ctx context.Context, endpoint string, params url.Values, token string, out interface{}) (int, error) {
+	apiURL := strings.TrimSuffix(c.BaseURL, "/") + endpoint
+	if token != "" && c.AuthMode == AuthModeQuery {
+		apiURL += "?token=" + url.QueryEscape(token)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" && c.AuthMode != AuthModeQuery {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return resp.StatusCode, fmt.Errorf("technitium API returned http 401 unauthorized")
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if result.Status != "ok" {
+		return resp.StatusCode, &APIError{
+			StatusCode:   resp.StatusCode,
+			Status:       result.Status,
+			ErrorMessage: result.ErrorMessage,
+			StackTrace:   result.StackTrace,
+		}
+	}
+
+	if out != nil && len(result.Response) > 0 {
+		if err := json.Unmarshal(result.Response, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to parse response payload: %v", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// cloneValues returns a shallow copy of v so callers can add params without
+// mutating the caller's url.Values.
+func cloneValues(v url.Values) url.Values {
+	clone := make(url.Values, len(v))
+	for key, values := range v {
+		clone[key] = values
+	}
+	return clone
+}
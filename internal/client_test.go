@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_SessionAuthLoginLogout(t *testing.T) {
+	var loggedIn bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/user/login":
+			loggedIn = true
+			json.NewEncoder(w).Encode(apiResponse{
+				Status:   "ok",
+				Response: json.RawMessage(`{"token":"session-token"}`),
+			})
+		case "/api/user/logout":
+			loggedIn = false
+			json.NewEncoder(w).Encode(apiResponse{Status: "ok"})
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{
+		BaseURL:    server.URL,
+		Username:   "admin",
+		Password:   "admin",
+		AuthMode:   AuthModeBearer,
+		HTTPClient: server.Client(),
+	}
+
+	if err := c.Login(context.Background()); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if !loggedIn {
+		t.Error("expected login endpoint to be called")
+	}
+	if c.sessionToken != "session-token" {
+		t.Errorf("expected cached session token, got %q", c.sessionToken)
+	}
+
+	if err := c.Logout(context.Background()); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if loggedIn {
+		t.Error("expected logout endpoint to be called")
+	}
+	if c.sessionToken != "" {
+		t.Error("expected session token to be cleared after logout")
+	}
+}
+
+func TestClient_AuthModeQuerySendsTokenInQueryString(t *testing.T) {
+	var gotQueryToken string
+	var gotAuthHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQueryToken = r.URL.Query().Get("token")
+		gotAuthHeader = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(apiResponse{Status: "ok"})
+	}))
+	defer server.Close()
+
+	c := &Client{
+		BaseURL:    server.URL,
+		APIToken:   "static-token",
+		AuthMode:   AuthModeQuery,
+		HTTPClient: server.Client(),
+	}
+
+	if err := c.AddRecord(context.Background(), "example.com", "TXT", 120, nil); err != nil {
+		t.Fatalf("AddRecord failed: %v", err)
+	}
+	if gotQueryToken != "static-token" {
+		t.Errorf("expected token in query string, got %q", gotQueryToken)
+	}
+	if gotAuthHeader != "" {
+		t.Errorf("expected no Authorization header under AuthModeQuery, got %q", gotAuthHeader)
+	}
+}
+
+func TestClient_AuthModeBearerSendsAuthorizationHeader(t *testing.T) {
+	var gotQueryToken string
+	var gotAuthHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQueryToken = r.URL.Query().Get("token")
+		gotAuthHeader = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(apiResponse{Status: "ok"})
+	}))
+	defer server.Close()
+
+	c := &Client{
+		BaseURL:    server.URL,
+		APIToken:   "static-token",
+		AuthMode:   AuthModeBearer,
+		HTTPClient: server.Client(),
+	}
+
+	if err := c.AddRecord(context.Background(), "example.com", "TXT", 120, nil); err != nil {
+		t.Fatalf("AddRecord failed: %v", err)
+	}
+	if gotAuthHeader != "Bearer static-token" {
+		t.Errorf("expected Authorization: Bearer static-token, got %q", gotAuthHeader)
+	}
+	if gotQueryToken != "" {
+		t.Errorf("expected no token query param under AuthModeBearer, got %q", gotQueryToken)
+	}
+}
+
+func TestClient_SurfacesAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(apiResponse{
+			Status:       "error",
+			ErrorMessage: "zone does not exist",
+			StackTrace:   "at ZoneManager.Get(...)",
+		})
+	}))
+	defer server.Close()
+
+	c := &Client{
+		BaseURL:    server.URL,
+		APIToken:   "static-token",
+		AuthMode:   AuthModeBearer,
+		HTTPClient: server.Client(),
+	}
+
+	_, err := c.GetZoneRecords(context.Background(), "example.com")
+	if err == nil {
+		t.Fatal("expected an error for a non-ok API response")
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError, got %T: %v", err, err)
+	}
+	if apiErr.Status != "error" || apiErr.ErrorMessage != "zone does not exist" {
+		t.Errorf("unexpected APIError fields: %+v", apiErr)
+	}
+}
+
+func TestClient_ReLoginsAndRetriesOn401(t *testing.T) {
+	var loginCount int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/user/login" {
+			n := atomic.AddInt32(&loginCount, 1)
+			json.NewEncoder(w).Encode(apiResponse{
+				Status:   "ok",
+				Response: json.RawMessage(fmt.Sprintf(`{"token":"token-%d"}`, n)),
+			})
+			return
+		}
+
+		// Only the token from the second login is accepted, simulating the
+		// first (cached) token having expired or been revoked server-side.
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(apiResponse{Status: "ok"})
+	}))
+	defer server.Close()
+
+	c := &Client{
+		BaseURL:    server.URL,
+		Username:   "admin",
+		Password:   "admin",
+		AuthMode:   AuthModeBearer,
+		HTTPClient: server.Client(),
+	}
+
+	if err := c.Login(context.Background()); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if c.sessionToken != "token-1" {
+		t.Fatalf("expected initial session token to be token-1, got %q", c.sessionToken)
+	}
+
+	if err := c.AddRecord(context.Background(), "example.com", "TXT", 120, nil); err != nil {
+		t.Fatalf("expected AddRecord to succeed after re-login on 401, got: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&loginCount); got != 2 {
+		t.Errorf("expected exactly one re-login after the 401, got %d total logins", got)
+	}
+	if c.sessionToken != "token-2" {
+		t.Errorf("expected the re-login's token to be cached, got %q", c.sessionToken)
+	}
+}
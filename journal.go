@@ -0,0 +1,170 @@
+package technitium
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+	"go.uber.org/zap"
+)
+
+// journalStorageKey is where the pending-additions journal is persisted via
+// the Caddy storage interface, so entries survive a crash between adding a
+// challenge record and the matching DeleteRecords call that would normally
+// remove it.
+const journalStorageKey = "dns_providers/technitium/journal.json"
+
+// journalCleanupGracePeriod is how long a journal entry must have been
+// pending before cleanupOrphanedJournal will treat it as orphaned and roll
+// it back. Caddy reprovisions the whole module graph on every config reload
+// (not just a process restart), so a sweep without a grace period would
+// delete challenge records that are still legitimately in flight (e.g.
+// mid multi-SAN batch, or awaiting CA validation) whenever a reload happens
+// to land during an ACME transaction. A crashed process, by contrast, never
+// clears its entries, so they're still there well past this window on the
+// next start.
+const journalCleanupGracePeriod = 10 * time.Minute
+
+// journalEntry records a single record addition that has been written to
+// Technitium but not yet confirmed removed, identified by its zone, name,
+// type, and value, so records of any type can be told apart.
+type journalEntry struct {
+	Zone  string `json:"zone"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// journalRecord pairs a journalEntry with the time it was added, so
+// cleanupOrphanedJournal can tell a stale entry left behind by a crashed
+// process from one that's merely in flight across a config reload.
+type journalRecord struct {
+	journalEntry
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// journalMu serializes read-modify-write access to the persisted journal.
+// The underlying storage backend may not offer atomic updates, so every
+// caller goes through this single in-process lock.
+var journalMu sync.Mutex
+
+// loadJournal returns the currently persisted journal records, or nil if
+// none have been persisted (or Provider.storage isn't available).
+func (p *Provider) loadJournal(ctx context.Context) ([]journalRecord, error) {
+	if p.storage == nil {
+		return nil, nil
+	}
+
+	data, err := p.storage.Load(ctx, journalStorageKey)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []journalRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// addJournalEntry persists entry as pending, stamped with the current time,
+// alongside whatever was already persisted. It's a no-op if Provider.storage
+// isn't available.
+func (p *Provider) addJournalEntry(ctx context.Context, entry journalEntry) error {
+	if p.storage == nil {
+		return nil
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	records, err := p.loadJournal(ctx)
+	if err != nil {
+		return err
+	}
+	records = append(records, journalRecord{journalEntry: entry, CreatedAt: time.Now()})
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return p.storage.Store(ctx, journalStorageKey, data)
+}
+
+// removeJournalEntry removes entry from the persisted journal, logging (but
+// not returning) any error, since a failure here only risks a redundant
+// rollback attempt on the next start rather than incorrect DNS state.
+func (p *Provider) removeJournalEntry(ctx context.Context, entry journalEntry) {
+	if p.storage == nil {
+		return
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	records, err := p.loadJournal(ctx)
+	if err != nil {
+		p.logger.Warn("failed to load journal while removing entry", zap.Error(err))
+		return
+	}
+
+	kept := records[:0]
+	for _, r := range records {
+		if r.journalEntry != entry {
+			kept = append(kept, r)
+		}
+	}
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		p.logger.Warn("failed to marshal journal while removing entry", zap.Error(err))
+		return
+	}
+	if err := p.storage.Store(ctx, journalStorageKey, data); err != nil {
+		p.logger.Warn("failed to persist journal after removing entry", zap.Error(err))
+	}
+}
+
+// cleanupOrphanedJournal removes any records left over from a previous
+// process that crashed mid-batch (after addRecord succeeded and the journal
+// entry was persisted, but before the entry could be cleared). It's called
+// once per Provision and is best-effort: a failure to delete a stale record
+// is logged, not returned, since it shouldn't prevent the provider from
+// otherwise starting up.
+//
+// Provision runs on every Caddy config reload, not just a process restart,
+// so entries younger than journalCleanupGracePeriod are left alone; they may
+// still be part of an in-flight ACME transaction that happened to overlap an
+// unrelated reload. Only entries old enough that a live transaction would
+// have completed or failed by now are treated as orphaned.
+func (p *Provider) cleanupOrphanedJournal(ctx context.Context) {
+	records, err := p.loadJournal(ctx)
+	if err != nil {
+		p.logger.Warn("failed to load journal for startup cleanup", zap.Error(err))
+		return
+	}
+
+	for _, record := range records {
+		if time.Since(record.CreatedAt) < journalCleanupGracePeriod {
+			continue
+		}
+
+		entry := record.journalEntry
+		rec := libdns.Record{Name: entry.Name, Type: entry.Type, Value: entry.Value}
+		if err := p.deleteRecord(ctx, rec); err != nil {
+			p.logger.Warn("failed to roll back orphaned record from previous run",
+				zap.String("name", entry.Name), zap.String("type", entry.Type), zap.Error(err))
+			continue
+		}
+		p.logger.Info("rolled back orphaned record from previous run",
+			zap.String("name", entry.Name), zap.String("type", entry.Type))
+		p.removeJournalEntry(ctx, entry)
+	}
+}
@@ -0,0 +1,111 @@
+package technitium
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+)
+
+func TestProvider_AppendRecords_RollsBackOnFailure(t *testing.T) {
+	p := &Provider{
+		ServerURL:   "https://localhost:5380",
+		APIToken:    "test-token",
+		HTTPTimeout: caddy.Duration(30 * time.Second),
+		TTL:         caddy.Duration(120 * time.Second),
+	}
+	if err := p.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("failed to provision: %v", err)
+	}
+
+	// Without a real Technitium server, the very first record fails to add,
+	// so the batch should come back empty and with no server calls left to
+	// roll back.
+	got, err := p.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "test-value"},
+	})
+	if err == nil {
+		t.Fatal("expected AppendRecords to fail without a reachable server")
+	}
+	if got != nil {
+		t.Errorf("expected AppendRecords to return no records after rolling back, got %v", got)
+	}
+}
+
+func newTestProviderWithStorage(t *testing.T) *Provider {
+	t.Helper()
+	p := &Provider{
+		ServerURL:   "https://localhost:5380",
+		APIToken:    "test-token",
+		HTTPTimeout: caddy.Duration(30 * time.Second),
+		TTL:         caddy.Duration(120 * time.Second),
+	}
+	if err := p.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("failed to provision: %v", err)
+	}
+	p.storage = &certmagic.FileStorage{Path: t.TempDir()}
+	return p
+}
+
+func TestCleanupOrphanedJournal_SkipsEntriesWithinGracePeriod(t *testing.T) {
+	p := newTestProviderWithStorage(t)
+	ctx := context.Background()
+
+	entry := journalEntry{Zone: "example.com", Name: "_acme-challenge.example.com.", Type: "TXT", Value: "pending"}
+	if err := p.addJournalEntry(ctx, entry); err != nil {
+		t.Fatalf("failed to seed journal: %v", err)
+	}
+
+	// A fresh entry (well within the grace period) must survive a reload,
+	// since it may still belong to an in-flight ACME transaction.
+	p.cleanupOrphanedJournal(ctx)
+
+	records, err := p.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("failed to load journal: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected the recent entry to survive cleanup, got %d entries", len(records))
+	}
+}
+
+func TestCleanupOrphanedJournal_KeepsStaleEntryWhenRollbackFails(t *testing.T) {
+	p := newTestProviderWithStorage(t)
+	ctx := context.Background()
+
+	entry := journalEntry{Zone: "example.com", Name: "_acme-challenge.example.com.", Type: "TXT", Value: "orphaned"}
+	if err := p.addJournalEntry(ctx, entry); err != nil {
+		t.Fatalf("failed to seed journal: %v", err)
+	}
+
+	// Backdate the entry past the grace period, as if it were left behind by
+	// a process that crashed well before this start.
+	records, err := p.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("failed to load journal: %v", err)
+	}
+	records[0].CreatedAt = time.Now().Add(-2 * journalCleanupGracePeriod)
+	data, err := json.Marshal(records)
+	if err != nil {
+		t.Fatalf("failed to marshal backdated journal: %v", err)
+	}
+	if err := p.storage.Store(ctx, journalStorageKey, data); err != nil {
+		t.Fatalf("failed to persist backdated journal: %v", err)
+	}
+
+	// Without a reachable Technitium server the rollback delete fails, so the
+	// stale entry is left in the journal rather than silently dropped.
+	p.cleanupOrphanedJournal(ctx)
+
+	records, err = p.loadJournal(ctx)
+	if err != nil {
+		t.Fatalf("failed to load journal: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected the stale entry to remain after a failed rollback attempt, got %d entries", len(records))
+	}
+}
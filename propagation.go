@@ -0,0 +1,131 @@
+package technitium
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// waitForPropagation blocks until every authoritative nameserver for zone
+// reports the expected TXT value for name, or returns an error once
+// propagation_timeout elapses. It is used after adding an ACME challenge
+// record so that AppendRecords does not return until the record is visible
+// to validators, which otherwise frequently race Technitium's own zone
+// transfer/replication and cause spurious "DNS problem: NXDOMAIN" errors.
+func (p *Provider) waitForPropagation(ctx context.Context, zone, name, value string) error {
+	nameservers, err := authoritativeNameservers(zone)
+	if err != nil {
+		return fmt.Errorf("failed to resolve authoritative nameservers for %s: %v", zone, err)
+	}
+	if len(nameservers) == 0 {
+		return fmt.Errorf("no authoritative nameservers found for %s", zone)
+	}
+
+	return p.pollNameservers(ctx, nameservers, name, value, nameserverHasTXT)
+}
+
+// pollNameservers is the polling loop behind waitForPropagation, split out
+// so tests can supply a check function backed by a fake DNS server instead
+// of a real nameserver on port 53.
+func (p *Provider) pollNameservers(ctx context.Context, nameservers []string, name, value string, check func(nameserver, name, value string) (bool, error)) error {
+	timeout := time.Duration(p.PropagationTimeout)
+	interval := time.Duration(p.PollingInterval)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		allPropagated := true
+		for _, ns := range nameservers {
+			ok, err := check(ns, name, value)
+			if err != nil {
+				p.logger.Debug("propagation check failed against nameserver",
+					zap.String("nameserver", ns), zap.String("name", name), zap.Error(err))
+				allPropagated = false
+				continue
+			}
+			if !ok {
+				allPropagated = false
+			}
+		}
+
+		if allPropagated {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s to propagate to %v", timeout, name, nameservers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// authoritativeNameservers returns the hostnames of the authoritative
+// nameservers for zone, resolved via the system's configured resolver.
+func authoritativeNameservers(zone string) ([]string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return nil, fmt.Errorf("failed to read system resolver configuration: %v", err)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(zone), dns.TypeNS)
+
+	client := new(dns.Client)
+	resp, _, err := client.Exchange(msg, net.JoinHostPort(conf.Servers[0], conf.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query NS records: %v", err)
+	}
+
+	var nameservers []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, strings.TrimSuffix(ns.Ns, "."))
+		}
+	}
+
+	return nameservers, nil
+}
+
+// nameserverHasTXT queries nameserver directly for the TXT record at name
+// and reports whether it returns the expected value.
+func nameserverHasTXT(nameserver, name, value string) (bool, error) {
+	return addrHasTXT(net.JoinHostPort(nameserver, "53"), name, value)
+}
+
+// addrHasTXT is the address-level half of nameserverHasTXT, split out so
+// tests can point it at a fake DNS server listening on a non-standard port
+// rather than requiring a real nameserver on port 53.
+func addrHasTXT(addr, name, value string) (bool, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	msg.RecursionDesired = false
+
+	client := new(dns.Client)
+	client.Timeout = 10 * time.Second
+
+	resp, _, err := client.Exchange(msg, addr)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rr := range resp.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		if strings.Join(txt.Txt, "") == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
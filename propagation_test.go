@@ -0,0 +1,145 @@
+package technitium
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// startFakeDNSServer starts a UDP DNS server on an ephemeral loopback port
+// running handler, and returns its address. The server is shut down when the
+// test completes.
+func startFakeDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() {
+		server.Shutdown()
+	})
+
+	return conn.LocalAddr().String()
+}
+
+// txtHandler replies to a TXT query with value, or with an empty (non-error)
+// answer section if respondValue is "".
+func txtHandler(respondValue func() string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+
+		if value := respondValue(); value != "" && len(r.Question) == 1 {
+			msg.Answer = append(msg.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{value},
+			})
+		}
+
+		w.WriteMsg(msg)
+	}
+}
+
+func TestAddrHasTXT(t *testing.T) {
+	addr := startFakeDNSServer(t, txtHandler(func() string { return "expected-value" }))
+
+	ok, err := addrHasTXT(addr, "_acme-challenge.example.com.", "expected-value")
+	if err != nil {
+		t.Fatalf("addrHasTXT failed: %v", err)
+	}
+	if !ok {
+		t.Error("expected addrHasTXT to find the matching TXT value")
+	}
+
+	ok, err = addrHasTXT(addr, "_acme-challenge.example.com.", "wrong-value")
+	if err != nil {
+		t.Fatalf("addrHasTXT failed: %v", err)
+	}
+	if ok {
+		t.Error("expected addrHasTXT to reject a non-matching TXT value")
+	}
+}
+
+func TestAddrHasTXT_UnreachableServer(t *testing.T) {
+	// Bind and immediately close a loopback UDP port so nothing answers.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	if _, err := addrHasTXT(addr, "_acme-challenge.example.com.", "expected-value"); err == nil {
+		t.Fatal("expected an error querying an unreachable nameserver")
+	}
+}
+
+func TestProvider_PollNameservers_AllMustAgree(t *testing.T) {
+	// ns1 agrees immediately; ns2 only starts agreeing after a couple of
+	// polls, exercising the "every nameserver must agree" requirement.
+	var ns2Polls int32
+	ns1 := startFakeDNSServer(t, txtHandler(func() string { return "challenge-value" }))
+	ns2 := startFakeDNSServer(t, txtHandler(func() string {
+		if atomic.AddInt32(&ns2Polls, 1) < 3 {
+			return ""
+		}
+		return "challenge-value"
+	}))
+
+	p := &Provider{
+		logger:             zap.NewNop(),
+		PropagationTimeout: caddy.Duration(2 * time.Second),
+		PollingInterval:    caddy.Duration(10 * time.Millisecond),
+	}
+
+	err := p.pollNameservers(context.Background(), []string{ns1, ns2}, "_acme-challenge.example.com.", "challenge-value", addrHasTXT)
+	if err != nil {
+		t.Fatalf("pollNameservers failed: %v", err)
+	}
+	if atomic.LoadInt32(&ns2Polls) < 3 {
+		t.Errorf("expected at least 3 polls against the slower nameserver, got %d", ns2Polls)
+	}
+}
+
+func TestProvider_PollNameservers_TimesOut(t *testing.T) {
+	ns := startFakeDNSServer(t, txtHandler(func() string { return "" }))
+
+	p := &Provider{
+		logger:             zap.NewNop(),
+		PropagationTimeout: caddy.Duration(30 * time.Millisecond),
+		PollingInterval:    caddy.Duration(10 * time.Millisecond),
+	}
+
+	err := p.pollNameservers(context.Background(), []string{ns}, "_acme-challenge.example.com.", "challenge-value", addrHasTXT)
+	if err == nil {
+		t.Fatal("expected pollNameservers to time out when no nameserver ever agrees")
+	}
+}
+
+func TestProvider_PollNameservers_ContextCanceled(t *testing.T) {
+	ns := startFakeDNSServer(t, txtHandler(func() string { return "" }))
+
+	p := &Provider{
+		logger:             zap.NewNop(),
+		PropagationTimeout: caddy.Duration(time.Minute),
+		PollingInterval:    caddy.Duration(time.Minute),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := p.pollNameservers(ctx, []string{ns}, "_acme-challenge.example.com.", "challenge-value", addrHasTXT)
+	if err == nil {
+		t.Fatal("expected pollNameservers to return an error when the context is already canceled")
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/caddy-dns/technitium/internal"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
 	"github.com/libdns/libdns"
 	"go.uber.org/zap"
 )
@@ -25,17 +27,55 @@ type Provider struct {
 	// The base URL of the Technitium DNS server
 	ServerURL string `json:"server_url,omitempty"`
 
-	// The API token for authentication
+	// The API token for authentication. Mutually exclusive with
+	// Username/Password.
 	APIToken string `json:"api_token,omitempty"`
 
+	// Username for logging in to obtain a session token, for deployments
+	// that only have user credentials rather than a permanent API token.
+	// Mutually exclusive with APIToken; requires Password.
+	Username string `json:"username,omitempty"`
+
+	// Password to log in with. Requires Username.
+	Password string `json:"password,omitempty"`
+
+	// How long a session token obtained via Username/Password is cached
+	// before forcing a re-login (default: never expire it proactively, and
+	// rely on 401 responses to trigger a re-login)
+	TokenTTL caddy.Duration `json:"token_ttl,omitempty"`
+
 	// HTTP timeout for API requests (default: 30s)
 	HTTPTimeout caddy.Duration `json:"http_timeout,omitempty"`
 
 	// TTL for TXT records (default: 120s)
 	TTL caddy.Duration `json:"ttl,omitempty"`
 
-	logger *zap.Logger
-	client *http.Client
+	// How long to wait for an added record to propagate to the zone's
+	// authoritative nameservers before giving up (default: 2m)
+	PropagationTimeout caddy.Duration `json:"propagation_timeout,omitempty"`
+
+	// How often to poll the authoritative nameservers while waiting for
+	// propagation (default: 2s)
+	PollingInterval caddy.Duration `json:"polling_interval,omitempty"`
+
+	// Skip waiting for propagation entirely after adding a record
+	DisablePropagationCheck bool `json:"disable_propagation_check,omitempty"`
+
+	// How the API token is presented to the server: "bearer" (default) sends
+	// an Authorization header; "query" falls back to a "token" query
+	// parameter for Technitium versions too old to accept bearer tokens.
+	AuthMode string `json:"auth_mode,omitempty"`
+
+	// Maps a domain to the FQDN its "_acme-challenge" TXT records should
+	// actually be written under (acme-dns style CNAME delegation), so ACME
+	// automation only ever needs write access to a dedicated challenge zone
+	// rather than the production zone. The key "*" matches any domain with
+	// no more specific entry.
+	AcmeDNSDelegation map[string]string `json:"acme_dns_delegation,omitempty"`
+
+	logger  *zap.Logger
+	client  *internal.Client
+	storage certmagic.Storage
 }
 
 // CaddyModule returns the Caddy module information
@@ -57,15 +97,75 @@ func (p *Provider) Provision(ctx caddy.Context) error {
 	if p.TTL == 0 {
 		p.TTL = caddy.Duration(120 * time.Second)
 	}
+	if p.PropagationTimeout == 0 {
+		p.PropagationTimeout = caddy.Duration(2 * time.Minute)
+	}
+	if p.PollingInterval == 0 {
+		p.PollingInterval = caddy.Duration(2 * time.Second)
+	}
+	if p.AuthMode == "" {
+		p.AuthMode = string(internal.AuthModeBearer)
+	}
 
-	// Create HTTP client
-	p.client = &http.Client{
-		Timeout: time.Duration(p.HTTPTimeout),
+	if p.APIToken != "" && p.Username != "" {
+		return fmt.Errorf("api_token and username/password are mutually exclusive")
+	}
+	if (p.Username == "") != (p.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	if p.APIToken == "" && p.Username == "" {
+		return fmt.Errorf("either api_token or username/password is required")
+	}
+
+	p.client = &internal.Client{
+		BaseURL:  p.ServerURL,
+		APIToken: p.APIToken,
+		Username: p.Username,
+		Password: p.Password,
+		AuthMode: internal.AuthMode(p.AuthMode),
+		TokenTTL: time.Duration(p.TokenTTL),
+		HTTPClient: &http.Client{
+			Timeout: time.Duration(p.HTTPTimeout),
+		},
+	}
+
+	if p.Username != "" {
+		if err := p.client.Login(context.Background()); err != nil {
+			return fmt.Errorf("failed to log in to Technitium: %v", err)
+		}
+	}
+
+	if storage := safeStorage(ctx); storage != nil {
+		p.storage = storage
+		p.cleanupOrphanedJournal(context.Background())
 	}
 
 	return nil
 }
 
+// safeStorage returns ctx's configured storage, or nil if none is available
+// -- which caddy.Context.Storage() indicates by panicking rather than
+// returning an error, when ctx wasn't built from a loaded Caddy config (as
+// in unit tests that provision a Provider directly). Journal persistence is
+// simply disabled in that case, same as if Caddy itself had no storage
+// configured.
+func safeStorage(ctx caddy.Context) (storage certmagic.Storage) {
+	defer func() {
+		if recover() != nil {
+			storage = nil
+		}
+	}()
+	return ctx.Storage()
+}
+
+// Cleanup logs out of the session established in Provision, if any.
+func (p *Provider) Cleanup() error {
+	if p.client == nil {
+		return nil
+	}
+	return p.client.Logout(context.Background())
+}
+
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler
 func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
@@ -84,6 +184,25 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				p.APIToken = d.Val()
+			case "username":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Username = d.Val()
+			case "password":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Password = d.Val()
+			case "token_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Err(err.Error())
+				}
+				p.TokenTTL = caddy.Duration(dur)
 			case "http_timeout":
 				if !d.NextArg() {
 					return d.ArgErr()
@@ -102,6 +221,50 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Err(err.Error())
 				}
 				p.TTL = caddy.Duration(dur)
+			case "propagation_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Err(err.Error())
+				}
+				p.PropagationTimeout = caddy.Duration(dur)
+			case "polling_interval":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Err(err.Error())
+				}
+				p.PollingInterval = caddy.Duration(dur)
+			case "disable_propagation_check":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DisablePropagationCheck = true
+			case "auth_mode":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				switch d.Val() {
+				case string(internal.AuthModeBearer), string(internal.AuthModeQuery):
+					p.AuthMode = d.Val()
+				default:
+					return d.Errf("invalid auth_mode %q; must be %q or %q", d.Val(), internal.AuthModeBearer, internal.AuthModeQuery)
+				}
+			case "acme_dns_delegation":
+				if p.AcmeDNSDelegation == nil {
+					p.AcmeDNSDelegation = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					domain := strings.TrimSuffix(d.Val(), ".")
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					p.AcmeDNSDelegation[domain] = strings.TrimSuffix(d.Val(), ".")
+				}
 			default:
 				return d.Errf("unrecognized subdirective '%s'", d.Val())
 			}
@@ -111,164 +274,417 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	if p.ServerURL == "" {
 		return d.Err("server_url is required")
 	}
-	if p.APIToken == "" {
-		return d.Err("api_token is required")
+	if p.APIToken == "" && p.Username == "" {
+		return d.Err("either api_token or username/password is required")
+	}
+	if p.APIToken != "" && p.Username != "" {
+		return d.Err("api_token and username/password are mutually exclusive")
+	}
+	if (p.Username == "") != (p.Password == "") {
+		return d.Err("username and password must be set together")
 	}
 
 	return nil
 }
 
-// AppendRecords adds records to the zone
+// AppendRecords adds records to the zone as a single transaction: every
+// successful addition is persisted to the journal, and if any record in the
+// batch fails (including failing to propagate), every record added so far
+// in this call is rolled back via deleteRecord. This keeps a multi-SAN or
+// wildcard issuance from leaving partial challenge records behind on a
+// mid-batch failure. Records that coalesce to the same (name, type, value)
+// -- e.g. repeated SANs sharing a key authorization -- share a single round
+// trip to Technitium rather than adding it redundantly; Technitium's add
+// API has no facility for batching distinct values in one call, so that's
+// the only coalescing available.
+//
+// A journal entry for a record that made it into this batch is only
+// cleared once the matching DeleteRecords call actually removes it (or once
+// it's rolled back here); if the process crashes in between, the entry is
+// picked up and rolled back by cleanupOrphanedJournal on the next start.
+//
+// For "_acme-challenge" TXT records, AcmeDNSDelegation (if configured)
+// rewrites the record to the delegated challenge zone before it's written;
+// the record returned to the caller still reflects the original name, since
+// that's the name ACME validators query (and which is expected to carry a
+// CNAME to the delegated name).
 func (p *Provider) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
 	var appendedRecords []libdns.Record
+	var added []journalEntry
+	seen := make(map[journalEntry]bool)
+
+	// Rollback runs its cleanup on a context of its own rather than the
+	// caller's ctx: the most likely way to land here is waitForPropagation
+	// failing because ctx hit certmagic's overall ACME deadline, in which
+	// case reusing ctx would make every cleanup call fail immediately and
+	// leave the record in the zone until cleanupOrphanedJournal's grace
+	// period expires on the next start.
+	rollback := func(cause error) ([]libdns.Record, error) {
+		cleanupCtx := context.Background()
+		for i := len(added) - 1; i >= 0; i-- {
+			entry := added[i]
+			record := libdns.Record{Name: entry.Name, Type: entry.Type, Value: entry.Value}
+			if err := p.deleteRecord(cleanupCtx, record); err != nil {
+				p.logger.Error("failed to roll back record after batch failure",
+					zap.String("name", entry.Name), zap.String("type", entry.Type), zap.Error(err))
+				continue
+			}
+			p.removeJournalEntry(cleanupCtx, entry)
+		}
+		// The batch is all-or-nothing: even if some records above couldn't
+		// be rolled back and remain in the zone, the batch as a whole did
+		// not succeed, so none of it is reported as created.
+		return nil, cause
+	}
 
 	for _, record := range records {
-		var recordData = record.RR()
-		if recordData.Type != "TXT" {
-			continue // Only handle TXT records for ACME challenges
+		record.Name = libdns.AbsoluteName(record.Name, zone)
+		if record.TTL == 0 {
+			record.TTL = time.Duration(p.TTL)
 		}
 
-		// Clean up the record name and zone
-		name := strings.TrimSuffix(recordData.Name, ".")
-		if !strings.HasSuffix(name, zone) {
-			name = name + "." + strings.TrimSuffix(zone, ".")
+		apiRecord := record
+		delegatedZone := zone
+		if record.Type == "TXT" {
+			apiRecord.Name, delegatedZone = p.delegatedChallengeName(zone, record.Name)
 		}
+		entry := journalEntry{Zone: zone, Name: apiRecord.Name, Type: apiRecord.Type, Value: apiRecord.Value}
 
-		err := p.addRecord(name, recordData.Data, int(time.Duration(p.TTL).Seconds()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to add TXT record for %s: %v", name, err)
+		if !seen[entry] {
+			if err := p.addJournalEntry(ctx, entry); err != nil {
+				return rollback(fmt.Errorf("failed to persist journal entry for %s: %v", apiRecord.Name, err))
+			}
+			if err := p.addRecord(ctx, apiRecord); err != nil {
+				p.removeJournalEntry(context.Background(), entry)
+				return rollback(fmt.Errorf("failed to add %s record for %s: %v", record.Type, apiRecord.Name, err))
+			}
+			added = append(added, entry)
+			seen[entry] = true
 		}
 
 		appendedRecords = append(appendedRecords, record)
-		p.logger.Info("Added TXT record", zap.String("name", name), zap.String("value", recordData.Data))
+		p.logger.Info("added record", zap.String("name", apiRecord.Name), zap.String("type", record.Type), zap.String("value", record.Value))
+
+		if record.Type == "TXT" && !p.DisablePropagationCheck {
+			if err := p.waitForPropagation(ctx, delegatedZone, apiRecord.Name, record.Value); err != nil {
+				return rollback(fmt.Errorf("record for %s was added but did not propagate: %v", apiRecord.Name, err))
+			}
+		}
 	}
 
 	return appendedRecords, nil
 }
 
-// DeleteRecords removes records from the zone
+// DeleteRecords removes records from the zone that exactly match the input,
+// and clears their journal entries (if any) now that they're confirmed
+// gone. Like AppendRecords, it honors AcmeDNSDelegation for
+// "_acme-challenge" TXT records so the delete targets the same delegated
+// record that was added.
 func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
 	var deletedRecords []libdns.Record
 
 	for _, record := range records {
-		var recordData = record.RR()
-		if recordData.Type != "TXT" {
-			continue // Only handle TXT records for ACME challenges
-		}
+		record.Name = libdns.AbsoluteName(record.Name, zone)
 
-		// Clean up the record name and zone
-		name := strings.TrimSuffix(recordData.Name, ".")
-		if !strings.HasSuffix(name, zone) {
-			name = name + "." + strings.TrimSuffix(zone, ".")
+		apiRecord := record
+		if record.Type == "TXT" {
+			apiRecord.Name, _ = p.delegatedChallengeName(zone, record.Name)
 		}
 
-		err := p.deleteRecord(name, recordData.Data)
-		if err != nil {
-			return nil, fmt.Errorf("failed to delete TXT record for %s: %v", name, err)
+		if err := p.deleteRecord(ctx, apiRecord); err != nil {
+			return deletedRecords, fmt.Errorf("failed to delete %s record for %s: %v", record.Type, apiRecord.Name, err)
 		}
+		p.removeJournalEntry(ctx, journalEntry{Zone: zone, Name: apiRecord.Name, Type: apiRecord.Type, Value: apiRecord.Value})
 
 		deletedRecords = append(deletedRecords, record)
-		p.logger.Info("Deleted TXT record", zap.String("name", name), zap.String("value", recordData.Data))
+		p.logger.Info("deleted record", zap.String("name", apiRecord.Name), zap.String("type", record.Type), zap.String("value", record.Value))
 	}
 
 	return deletedRecords, nil
 }
 
-// GetRecords retrieves records from the zone
-func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
-	// This method is not required for ACME DNS challenges
-	// but can be implemented if needed
-	return nil, fmt.Errorf("GetRecords not implemented")
-}
-
-// SetRecords replaces records in the zone
-func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	// This method is not required for ACME DNS challenges
-	// but can be implemented if needed
-	return nil, fmt.Errorf("SetRecords not implemented")
-}
+// delegatedChallengeName returns the FQDN that an "_acme-challenge" TXT
+// record should actually be written to per AcmeDNSDelegation, along with the
+// zone that name belongs to (for propagation checks). If name isn't an
+// "_acme-challenge" record, or no delegation applies to zone, it returns
+// name and zone unchanged.
+func (p *Provider) delegatedChallengeName(zone, name string) (delegatedName, delegatedZone string) {
+	if len(p.AcmeDNSDelegation) == 0 || !strings.HasPrefix(name, "_acme-challenge.") {
+		return name, zone
+	}
 
-// addRecord adds a TXT record via Technitium API
-func (p *Provider) addRecord(domain, text string, ttl int) error {
-	apiURL := fmt.Sprintf("%s/api/zones/records/add", strings.TrimSuffix(p.ServerURL, "/"))
+	target, ok := p.AcmeDNSDelegation[strings.TrimSuffix(zone, ".")]
+	if !ok {
+		target, ok = p.AcmeDNSDelegation["*"]
+	}
+	if !ok {
+		return name, zone
+	}
 
-	params := url.Values{}
-	params.Set("token", p.APIToken)
-	params.Set("domain", domain)
-	params.Set("type", "TXT")
-	params.Set("ttl", fmt.Sprintf("%d", ttl))
-	params.Set("text", text)
+	return libdns.AbsoluteName("_acme-challenge", target), target
+}
 
-	req, err := http.NewRequest("GET", apiURL+"?"+params.Encode(), nil)
+// GetRecords retrieves all records in the zone from the Technitium server.
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	apiRecords, err := p.client.GetZoneRecords(ctx, zone)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to get records for zone %s: %v", zone, err)
 	}
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %v", err)
+	records := make([]libdns.Record, 0, len(apiRecords))
+	for _, rec := range apiRecords {
+		record, err := recordFromAPI(rec)
+		if err != nil {
+			p.logger.Warn("skipping unparseable record", zap.String("name", rec.Name), zap.String("type", rec.Type), zap.Error(err))
+			continue
+		}
+		records = append(records, record)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	return records, nil
+}
+
+// SetRecords updates the zone so that the records described in the input are
+// reflected in the output, fetching the current state of the zone first. Each
+// input record updates a remaining existing record of the same name and type
+// in place (even one that already matches), or is added if none remains, and
+// every existing record not reused this way is deleted.
+func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	existing, err := p.GetRecords(ctx, zone)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to read existing records before SetRecords: %v", err)
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+	type rrsetKey struct {
+		name string
+		typ  string
 	}
-
-	if status, ok := result["status"].(string); !ok || status != "ok" {
-		return fmt.Errorf("API returned error: %s", string(body))
+	existingByRRset := make(map[rrsetKey][]libdns.Record)
+	for _, record := range existing {
+		key := rrsetKey{name: record.Name, typ: record.Type}
+		existingByRRset[key] = append(existingByRRset[key], record)
 	}
 
-	return nil
-}
+	var setRecords []libdns.Record
 
-// deleteRecord deletes a TXT record via Technitium API
-func (p *Provider) deleteRecord(domain, text string) error {
-	apiURL := fmt.Sprintf("%s/api/zones/records/delete", strings.TrimSuffix(p.ServerURL, "/"))
+	for _, record := range records {
+		record.Name = libdns.AbsoluteName(record.Name, zone)
+		if record.TTL == 0 {
+			record.TTL = time.Duration(p.TTL)
+		}
+		key := rrsetKey{name: record.Name, typ: record.Type}
+
+		var replaced bool
+		if remaining := existingByRRset[key]; len(remaining) > 0 {
+			// Reuse (i.e. update in place) the first remaining record of this
+			// RRset rather than deleting and re-adding it.
+			if err := p.updateRecord(ctx, remaining[0], record); err != nil {
+				return setRecords, fmt.Errorf("failed to update %s record for %s: %v", record.Type, record.Name, err)
+			}
+			existingByRRset[key] = remaining[1:]
+			replaced = true
+		} else {
+			if err := p.addRecord(ctx, record); err != nil {
+				return setRecords, fmt.Errorf("failed to add %s record for %s: %v", record.Type, record.Name, err)
+			}
+		}
 
-	params := url.Values{}
-	params.Set("token", p.APIToken)
-	params.Set("domain", domain)
-	params.Set("type", "TXT")
-	params.Set("text", text)
+		setRecords = append(setRecords, record)
+
+		action := "added"
+		if replaced {
+			action = "updated"
+		}
+		p.logger.Info(action+" record", zap.String("name", record.Name), zap.String("type", record.Type), zap.String("value", record.Value))
+	}
 
-	req, err := http.NewRequest("GET", apiURL+"?"+params.Encode(), nil)
+	// Anything left over in existingByRRset was present before but is not
+	// part of the desired input, so it must be removed to maintain parity.
+	for _, remaining := range existingByRRset {
+		for _, record := range remaining {
+			if err := p.deleteRecord(ctx, record); err != nil {
+				return setRecords, fmt.Errorf("failed to delete stale %s record for %s: %v", record.Type, record.Name, err)
+			}
+			p.logger.Info("deleted stale record", zap.String("name", record.Name), zap.String("type", record.Type), zap.String("value", record.Value))
+		}
+	}
+
+	return setRecords, nil
+}
+
+// addRecord adds a record via the Technitium zones/records/add API.
+func (p *Provider) addRecord(ctx context.Context, record libdns.Record) error {
+	params, err := recordParams(record)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return err
 	}
+	return p.client.AddRecord(ctx, record.Name, record.Type, int(record.TTL.Seconds()), params)
+}
 
-	resp, err := p.client.Do(req)
+// deleteRecord removes a record via the Technitium zones/records/delete API.
+// Technitium matches on the identifying value fields, so the TTL is omitted.
+func (p *Provider) deleteRecord(ctx context.Context, record libdns.Record) error {
+	params, err := recordParams(record)
 	if err != nil {
-		return fmt.Errorf("failed to make request: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
+	return p.client.DeleteRecord(ctx, record.Name, record.Type, params)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// updateRecord replaces an existing record with a new one in place via the
+// Technitium zones/records/update API, which identifies the record to change
+// by its current value and carries the new value in "new"-prefixed params.
+func (p *Provider) updateRecord(ctx context.Context, old, new libdns.Record) error {
+	oldParams, err := recordParams(old)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return err
+	}
+	newParams, err := recordParams(new)
+	if err != nil {
+		return err
+	}
+	return p.client.UpdateRecord(ctx, old.Name, old.Type, oldParams, new.Name, int(new.TTL.Seconds()), newParams)
+}
+
+// recordParams translates a libdns.Record into the type-specific parameters
+// understood by the Technitium zones/records API. The returned values do not
+// include the common "domain", "type", or "ttl" params, since those are set
+// by internal.Client depending on which endpoint is being used.
+//
+// libdns.Record doesn't have dedicated fields for every RR type, so
+// multi-value types are packed into Value as documented below (Priority is
+// the one extra field libdns affords MX, SRV, and URI records).
+func recordParams(record libdns.Record) (url.Values, error) {
+	params := url.Values{}
+
+	switch record.Type {
+	case "A", "AAAA":
+		params.Set("ipAddress", record.Value)
+	case "CNAME":
+		params.Set("cname", record.Value)
+	case "NS":
+		params.Set("nameServer", record.Value)
+	case "MX":
+		// Value is the mail exchange target; Priority is the preference.
+		params.Set("preference", strconv.Itoa(record.Priority))
+		params.Set("exchange", record.Value)
+	case "SRV":
+		// Value packs "weight port target"; Priority is the SRV priority.
+		fields := strings.Fields(record.Value)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf(`malformed SRV value %q; expected "weight port target"`, record.Value)
+		}
+		params.Set("priority", strconv.Itoa(record.Priority))
+		params.Set("weight", fields[0])
+		params.Set("port", fields[1])
+		params.Set("target", fields[2])
+	case "CAA":
+		// Value packs "flags tag value".
+		fields := strings.SplitN(record.Value, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf(`malformed CAA value %q; expected "flags tag value"`, record.Value)
+		}
+		params.Set("flags", fields[0])
+		params.Set("tag", fields[1])
+		params.Set("value", strings.Trim(fields[2], `"`))
+	case "TXT":
+		params.Set("text", record.Value)
+	default:
+		return nil, fmt.Errorf("unsupported record type: %s", record.Type)
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+	return params, nil
+}
+
+// recordFromAPI converts an internal.Record (as returned by
+// zones/records/get) into a libdns.Record, packing multi-value RR data into
+// Value per the convention documented on recordParams.
+func recordFromAPI(rec internal.Record) (libdns.Record, error) {
+	record := libdns.Record{
+		Name: rec.Name,
+		Type: rec.Type,
+		TTL:  time.Duration(rec.TTL) * time.Second,
 	}
 
-	if status, ok := result["status"].(string); !ok || status != "ok" {
-		return fmt.Errorf("API returned error: %s", string(body))
+	switch rec.Type {
+	case "A", "AAAA":
+		var data struct {
+			IPAddress string `json:"ipAddress"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Value = data.IPAddress
+	case "CNAME":
+		var data struct {
+			CNAME string `json:"cname"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Value = data.CNAME
+	case "NS":
+		var data struct {
+			NameServer string `json:"nameServer"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Value = data.NameServer
+	case "MX":
+		var data struct {
+			Preference int    `json:"preference"`
+			Exchange   string `json:"exchange"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Priority = data.Preference
+		record.Value = data.Exchange
+	case "SRV":
+		var data struct {
+			Priority int    `json:"priority"`
+			Weight   int    `json:"weight"`
+			Port     int    `json:"port"`
+			Target   string `json:"target"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Priority = data.Priority
+		record.Value = fmt.Sprintf("%d %d %s", data.Weight, data.Port, data.Target)
+	case "CAA":
+		var data struct {
+			Flags int    `json:"flags"`
+			Tag   string `json:"tag"`
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Value = fmt.Sprintf("%d %s %q", data.Flags, data.Tag, data.Value)
+	case "TXT":
+		var data struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(rec.RData, &data); err != nil {
+			return libdns.Record{}, err
+		}
+		record.Value = data.Text
+	default:
+		return libdns.Record{}, fmt.Errorf("unsupported record type: %s", rec.Type)
 	}
 
-	return nil
+	return record, nil
 }
 
 // Interface guards
 var (
 	_ caddyfile.Unmarshaler = (*Provider)(nil)
 	_ caddy.Provisioner     = (*Provider)(nil)
+	_ caddy.CleanerUpper    = (*Provider)(nil)
+	_ libdns.RecordGetter   = (*Provider)(nil)
 	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordSetter   = (*Provider)(nil)
 	_ libdns.RecordDeleter  = (*Provider)(nil)
 )
@@ -2,9 +2,16 @@ package technitium
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/caddy-dns/technitium/internal"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/libdns/libdns"
 )
@@ -47,6 +54,257 @@ func TestProvider_AppendRecords(t *testing.T) {
 	}
 }
 
+func TestProvider_AppendRecords_RollbackReturnsNoRecords(t *testing.T) {
+	var mu sync.Mutex
+	var adds, deletes int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/api/zones/records/add":
+			adds++
+			if adds == 2 {
+				fmt.Fprint(w, `{"status":"error","errorMessage":"simulated failure"}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"ok"}`)
+		case "/api/zones/records/delete":
+			deletes++
+			fmt.Fprint(w, `{"status":"ok"}`)
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	p := &Provider{
+		ServerURL:               server.URL,
+		APIToken:                "test-token",
+		HTTPTimeout:             caddy.Duration(30 * time.Second),
+		TTL:                     caddy.Duration(120 * time.Second),
+		DisablePropagationCheck: true,
+	}
+	if err := p.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("failed to provision: %v", err)
+	}
+
+	got, err := p.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		{Type: "TXT", Name: "first", Value: "one"},
+		{Type: "TXT", Name: "second", Value: "two"},
+	})
+	if err == nil {
+		t.Fatal("expected AppendRecords to fail when the second add fails")
+	}
+	if got != nil {
+		t.Errorf("expected AppendRecords to return no records after a full rollback, got %v", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if deletes != 1 {
+		t.Errorf("expected the first record to be rolled back via one delete call, got %d", deletes)
+	}
+}
+
+func TestProvider_DelegatedChallengeName(t *testing.T) {
+	p := &Provider{
+		AcmeDNSDelegation: map[string]string{
+			"example.com": "challenge.acme.internal",
+			"*":           "default.acme.internal",
+		},
+	}
+
+	name, zone := p.delegatedChallengeName("example.com", "_acme-challenge.example.com")
+	if name != "_acme-challenge.challenge.acme.internal" || zone != "challenge.acme.internal" {
+		t.Errorf("expected delegation to example.com's entry, got name=%q zone=%q", name, zone)
+	}
+
+	name, zone = p.delegatedChallengeName("other.com", "_acme-challenge.other.com")
+	if name != "_acme-challenge.default.acme.internal" || zone != "default.acme.internal" {
+		t.Errorf("expected fallback to wildcard entry, got name=%q zone=%q", name, zone)
+	}
+
+	name, zone = p.delegatedChallengeName("example.com", "www.example.com")
+	if name != "www.example.com" || zone != "example.com" {
+		t.Errorf("expected non-challenge records to pass through unchanged, got name=%q zone=%q", name, zone)
+	}
+}
+
+func TestRecordParamsAndRecordFromAPI_RoundTrip(t *testing.T) {
+	tests := []struct {
+		recordType string
+		value      string
+		priority   int
+		wantParams map[string]string
+		rData      string
+	}{
+		{
+			recordType: "A",
+			value:      "192.0.2.1",
+			wantParams: map[string]string{"ipAddress": "192.0.2.1"},
+			rData:      `{"ipAddress":"192.0.2.1"}`,
+		},
+		{
+			recordType: "AAAA",
+			value:      "2001:db8::1",
+			wantParams: map[string]string{"ipAddress": "2001:db8::1"},
+			rData:      `{"ipAddress":"2001:db8::1"}`,
+		},
+		{
+			recordType: "CNAME",
+			value:      "target.example.com.",
+			wantParams: map[string]string{"cname": "target.example.com."},
+			rData:      `{"cname":"target.example.com."}`,
+		},
+		{
+			recordType: "NS",
+			value:      "ns1.example.com.",
+			wantParams: map[string]string{"nameServer": "ns1.example.com."},
+			rData:      `{"nameServer":"ns1.example.com."}`,
+		},
+		{
+			recordType: "MX",
+			value:      "mail.example.com.",
+			priority:   10,
+			wantParams: map[string]string{"preference": "10", "exchange": "mail.example.com."},
+			rData:      `{"preference":10,"exchange":"mail.example.com."}`,
+		},
+		{
+			recordType: "SRV",
+			value:      "5 5060 sip.example.com.",
+			priority:   10,
+			wantParams: map[string]string{"priority": "10", "weight": "5", "port": "5060", "target": "sip.example.com."},
+			rData:      `{"priority":10,"weight":5,"port":5060,"target":"sip.example.com."}`,
+		},
+		{
+			recordType: "CAA",
+			value:      `0 issue "letsencrypt.org"`,
+			wantParams: map[string]string{"flags": "0", "tag": "issue", "value": "letsencrypt.org"},
+			rData:      `{"flags":0,"tag":"issue","value":"letsencrypt.org"}`,
+		},
+		{
+			recordType: "TXT",
+			value:      "hello world",
+			wantParams: map[string]string{"text": "hello world"},
+			rData:      `{"text":"hello world"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recordType, func(t *testing.T) {
+			record := libdns.Record{Type: tt.recordType, Name: "www.example.com.", Value: tt.value, Priority: tt.priority}
+
+			params, err := recordParams(record)
+			if err != nil {
+				t.Fatalf("recordParams failed: %v", err)
+			}
+			for key, want := range tt.wantParams {
+				if got := params.Get(key); got != want {
+					t.Errorf("recordParams()[%q] = %q, want %q", key, got, want)
+				}
+			}
+
+			apiRecord := internal.Record{Name: record.Name, Type: tt.recordType, TTL: 120, RData: json.RawMessage(tt.rData)}
+			got, err := recordFromAPI(apiRecord)
+			if err != nil {
+				t.Fatalf("recordFromAPI failed: %v", err)
+			}
+			if got.Value != tt.value || got.Priority != tt.priority {
+				t.Errorf("recordFromAPI round trip = {Value: %q, Priority: %d}, want {Value: %q, Priority: %d}",
+					got.Value, got.Priority, tt.value, tt.priority)
+			}
+		})
+	}
+}
+
+func TestRecordParams_MalformedSRVValue(t *testing.T) {
+	_, err := recordParams(libdns.Record{Type: "SRV", Name: "_sip._tcp.example.com.", Value: "not-enough-fields"})
+	if err == nil {
+		t.Fatal("expected an error for a malformed SRV value")
+	}
+}
+
+func TestRecordParams_MalformedCAAValue(t *testing.T) {
+	_, err := recordParams(libdns.Record{Type: "CAA", Name: "example.com.", Value: "0 issue"})
+	if err == nil {
+		t.Fatal("expected an error for a malformed CAA value")
+	}
+}
+
+func TestRecordParams_UnsupportedType(t *testing.T) {
+	_, err := recordParams(libdns.Record{Type: "SOA", Name: "example.com.", Value: "whatever"})
+	if err == nil {
+		t.Fatal("expected an error for an unsupported record type")
+	}
+}
+
+func TestProvider_SetRecords_Reconciliation(t *testing.T) {
+	var mu sync.Mutex
+	var adds, updates, deletes []url.Values
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("failed to parse request form: %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/api/zones/records/get":
+			fmt.Fprint(w, `{"status":"ok","response":{"records":[
+				{"name":"update-me.example.com","type":"TXT","ttl":120,"rData":{"text":"old"}},
+				{"name":"stale.example.com","type":"TXT","ttl":120,"rData":{"text":"remove-me"}}
+			]}}`)
+		case "/api/zones/records/add":
+			adds = append(adds, r.Form)
+			fmt.Fprint(w, `{"status":"ok"}`)
+		case "/api/zones/records/update":
+			updates = append(updates, r.Form)
+			fmt.Fprint(w, `{"status":"ok"}`)
+		case "/api/zones/records/delete":
+			deletes = append(deletes, r.Form)
+			fmt.Fprint(w, `{"status":"ok"}`)
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	p := &Provider{
+		ServerURL:   server.URL,
+		APIToken:    "test-token",
+		HTTPTimeout: caddy.Duration(30 * time.Second),
+		TTL:         caddy.Duration(120 * time.Second),
+	}
+	if err := p.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("failed to provision: %v", err)
+	}
+
+	_, err := p.SetRecords(context.Background(), "example.com", []libdns.Record{
+		{Type: "TXT", Name: "update-me", Value: "new"},
+		{Type: "TXT", Name: "new-record", Value: "brand-new"},
+	})
+	if err != nil {
+		t.Fatalf("SetRecords failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(adds) != 1 || adds[0].Get("domain") != "new-record.example.com" {
+		t.Errorf("expected one add for new-record.example.com, got %v", adds)
+	}
+	if len(updates) != 1 || updates[0].Get("domain") != "update-me.example.com" || updates[0].Get("newText") != "new" {
+		t.Errorf("expected one update for update-me.example.com with newText=new, got %v", updates)
+	}
+	if len(deletes) != 1 || deletes[0].Get("domain") != "stale.example.com" {
+		t.Errorf("expected one delete for stale.example.com, got %v", deletes)
+	}
+}
+
 func TestProvider_CaddyModule(t *testing.T) {
 	p := Provider{}
 	mod := p.CaddyModule()